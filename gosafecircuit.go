@@ -6,24 +6,64 @@ import (
 	"time"
 )
 
-// CircuitBreaker represents a circuit breaker.
-type CircuitBreaker struct {
-	mutex                   sync.Mutex
-	state                   State
-	consecutiveFailures     int
-	totalFailures           int
-	totalSuccesses          int
-	maxFailures             int
-	timeout                 time.Duration
-	openTimeout             time.Time
-	pauseTime               time.Duration // Added pause time between retries in HALF-OPEN state
-	consecutiveSuccesses    int
-	maxConsecutiveSuccesses int
-	onOpen                  func()
-	onClose                 func()
-	onHalfOpen              func()
+// Counts holds the request/outcome tallies the circuit breaker hands to
+// ReadyToTrip. Requests/TotalSuccesses/TotalFailures are aggregated
+// over the sliding window; ConsecutiveSuccesses/ConsecutiveFailures are
+// running counters that are not bucketed, since a streak spanning
+// several buckets would otherwise reset at every bucket boundary.
+type Counts struct {
+	Requests             uint32
+	TotalSuccesses       uint32
+	TotalFailures        uint32
+	ConsecutiveSuccesses uint32
+	ConsecutiveFailures  uint32
+}
+
+// windowCounts is one bucket's share of the sliding window. Unlike
+// Counts, it carries no consecutive-streak fields: those are tracked
+// once on Tracking instead of per bucket, so a streak isn't clipped at
+// a bucket boundary.
+type windowCounts struct {
+	requests       uint32
+	totalSuccesses uint32
+	totalFailures  uint32
+}
+
+func (c *windowCounts) onSuccess() {
+	c.requests++
+	c.totalSuccesses++
+}
+
+func (c *windowCounts) onFailure() {
+	c.requests++
+	c.totalFailures++
+}
+
+// bucket is one slot of the sliding window used to aggregate Counts.
+type bucket struct {
+	start time.Time
+	windowCounts
 }
 
+// numBuckets is how many buckets an Interval is divided into.
+const numBuckets = 10
+
+// defaultReadyToTrip trips once the window has seen at least 20
+// requests and more than 60% of them failed.
+func defaultReadyToTrip(counts Counts) bool {
+	failureRatio := float64(counts.TotalFailures) / float64(counts.Requests)
+	return counts.Requests >= 20 && failureRatio > 0.6
+}
+
+// ErrOpenState is returned by BeforeRequest (and, through it, Execute)
+// when the breaker is open and not yet due for a HALF-OPEN probe.
+var ErrOpenState = errors.New("circuit breaker is open")
+
+// ErrTooManyRequests is returned by BeforeRequest when the breaker is
+// HALF-OPEN and MaxRequests probes have already been admitted in the
+// current HALF-OPEN period, whether or not they have completed.
+var ErrTooManyRequests = errors.New("too many requests")
+
 // State represents the state of the circuit breaker.
 type State int
 
@@ -33,96 +73,408 @@ const (
 	StateHalfOpen
 )
 
-// NewCircuitBreaker creates a new CircuitBreaker instance.
-func NewCircuitBreaker(maxFailures int, timeout time.Duration, pauseTime time.Duration, maxConsecutiveSuccesses int) *CircuitBreaker {
-	return &CircuitBreaker{
-		state:                   StateClosed,
-		maxFailures:             maxFailures,
-		timeout:                 timeout,
-		openTimeout:             time.Time{},
-		pauseTime:               pauseTime,
-		maxConsecutiveSuccesses: maxConsecutiveSuccesses,
+// Tracking holds the state machine and counters behind a circuit
+// breaker, decoupled from the func() error call shape that Execute
+// imposes. Callers that can't express their call site as a closure -
+// database/sql drivers, go-redis hooks, gRPC interceptors - can drive
+// the breaker directly with BeforeRequest/AfterRequest instead.
+//
+// Every state transition bumps a generation counter; AfterRequest
+// discards outcomes reported against a stale generation so a success
+// that started before a trip can't prematurely close the breaker
+// after it reopens.
+type Tracking struct {
+	mutex                sync.Mutex
+	state                State
+	generation           uint64
+	timeout              time.Duration
+	openTimeout          time.Time
+	maxRequests          int
+	halfOpenRequests     int // probes admitted in the current HALF-OPEN generation
+	halfOpenDone         int // probes completed in the current HALF-OPEN generation
+	consecutiveSuccesses uint32
+	consecutiveFailures  uint32
+	readyToTrip          func(Counts) bool
+	interval             time.Duration
+	bucketPeriod         time.Duration
+	buckets              []bucket
+	onStateChange        func(from, to State)
+}
+
+// NewTracking creates a new Tracking instance. Counts are aggregated
+// over a sliding window of the given interval, split into buckets that
+// are dropped as they expire; readyToTrip is evaluated against the
+// aggregated Counts after every failure while closed. A nil
+// readyToTrip falls back to tripping once the window has at least 20
+// requests with a failure ratio above 60%. maxRequests caps how many
+// probes are admitted per HALF-OPEN period (a completed probe does not
+// free a slot); once that many have completed with no failures, the
+// breaker closes.
+func NewTracking(timeout time.Duration, maxRequests int, interval time.Duration, readyToTrip func(Counts) bool) *Tracking {
+	if readyToTrip == nil {
+		readyToTrip = defaultReadyToTrip
+	}
+	if interval <= 0 {
+		interval = timeout
+	}
+	if maxRequests <= 0 {
+		maxRequests = 1
+	}
+	bucketPeriod := interval / numBuckets
+	if bucketPeriod <= 0 {
+		bucketPeriod = interval
+	}
+	return &Tracking{
+		state:        StateClosed,
+		timeout:      timeout,
+		maxRequests:  maxRequests,
+		readyToTrip:  readyToTrip,
+		interval:     interval,
+		bucketPeriod: bucketPeriod,
 	}
 }
 
-// Execute executes the given function with circuit breaker logic.
-func (cb *CircuitBreaker) Execute(fn func() error) error {
-	cb.mutex.Lock()
-	defer cb.mutex.Unlock()
+// BeforeRequest reports whether a request may proceed. It returns the
+// generation the request is running against; pass it back to
+// AfterRequest so outcomes from before a state transition are ignored.
+// While HALF-OPEN, up to MaxRequests probes are admitted per HALF-OPEN
+// period; callers beyond that budget get ErrTooManyRequests immediately
+// instead of blocking, even if earlier probes have already completed.
+func (t *Tracking) BeforeRequest() (uint64, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 
-	switch cb.state {
-	case StateOpen:
-		if time.Now().After(cb.openTimeout) {
-			cb.state = StateHalfOpen
-			if cb.onHalfOpen != nil {
-				cb.onHalfOpen()
-			}
+	now := time.Now()
+	if t.state == StateOpen {
+		if now.After(t.openTimeout) {
+			t.setState(StateHalfOpen, now)
 		} else {
-			return errors.New("circuit breaker is open")
+			return t.generation, ErrOpenState
 		}
-	case StateHalfOpen:
-		// Try the operation
-		err := fn()
-		if err == nil {
-			cb.consecutiveSuccesses++
-			cb.totalSuccesses++
-			if cb.consecutiveSuccesses >= cb.maxConsecutiveSuccesses {
-				cb.reset()
-			}
-		} else {
-			cb.trip()
+	}
+	if t.state == StateHalfOpen {
+		if t.halfOpenRequests >= t.maxRequests {
+			return t.generation, ErrTooManyRequests
 		}
-		time.Sleep(cb.pauseTime) // Pause before next try in HALF-OPEN state
-		return err
+		t.halfOpenRequests++
 	}
+	return t.generation, nil
+}
 
-	// Execute the operation
-	err := fn()
-	if err == nil {
-		cb.reset()
-		cb.totalSuccesses++
+// AfterRequest records the outcome of a request previously admitted by
+// BeforeRequest. Outcomes reported against a generation that is no
+// longer current are discarded.
+func (t *Tracking) AfterRequest(generation uint64, success bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if generation != t.generation {
+		return
+	}
+	if success {
+		t.onSuccess()
 	} else {
-		cb.consecutiveFailures++
-		cb.totalFailures++
-		if cb.consecutiveFailures >= cb.maxFailures {
-			cb.trip()
+		t.onFailure()
+	}
+}
+
+// State returns the current state of the breaker.
+func (t *Tracking) State() State {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.state
+}
+
+// Counts returns the Counts aggregated over the current sliding
+// window.
+func (t *Tracking) Counts() Counts {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.counts()
+}
+
+func (t *Tracking) onSuccess() {
+	switch t.state {
+	case StateClosed:
+		t.recordSuccess()
+	case StateHalfOpen:
+		t.recordSuccess()
+		t.halfOpenDone++
+		if t.halfOpenDone >= t.maxRequests {
+			t.setState(StateClosed, time.Now())
 		}
 	}
-	return err
 }
 
-// trip trips the circuit breaker to the open state.
-func (cb *CircuitBreaker) trip() {
-	cb.state = StateOpen
-	cb.consecutiveFailures = 0
-	cb.consecutiveSuccesses = 0
-	cb.openTimeout = time.Now().Add(cb.timeout)
-	if cb.onOpen != nil {
-		cb.onOpen()
+func (t *Tracking) onFailure() {
+	switch t.state {
+	case StateClosed:
+		t.recordFailure()
+		if t.readyToTrip(t.counts()) {
+			t.setState(StateOpen, time.Now())
+		}
+	case StateHalfOpen:
+		t.recordFailure()
+		t.setState(StateOpen, time.Now())
+	}
+}
+
+// setState transitions to the given state, bumping the generation and
+// resetting per-state bookkeeping. Must be called with t.mutex held.
+func (t *Tracking) setState(state State, now time.Time) {
+	if t.state == state {
+		return
+	}
+	from := t.state
+	t.state = state
+	t.generation++
+	t.halfOpenRequests = 0
+	t.halfOpenDone = 0
+	t.consecutiveSuccesses = 0
+	t.consecutiveFailures = 0
+	t.buckets = nil
+
+	if state == StateOpen {
+		t.openTimeout = now.Add(t.timeout)
+	}
+	if t.onStateChange != nil {
+		t.onStateChange(from, state)
+	}
+}
+
+// currentBucket returns the bucket covering time.Now(), rotating the
+// window and dropping expired buckets first. Must be called with
+// t.mutex held.
+func (t *Tracking) currentBucket(now time.Time) *bucket {
+	t.rotate(now)
+	if len(t.buckets) == 0 {
+		t.buckets = append(t.buckets, bucket{start: now})
+	}
+	return &t.buckets[len(t.buckets)-1]
+}
+
+// rotate appends a new bucket once the latest one has aged past
+// bucketPeriod and drops buckets that have fallen out of interval.
+// Must be called with t.mutex held.
+func (t *Tracking) rotate(now time.Time) {
+	if len(t.buckets) > 0 {
+		if last := &t.buckets[len(t.buckets)-1]; now.Sub(last.start) >= t.bucketPeriod {
+			t.buckets = append(t.buckets, bucket{start: now})
+		}
+	}
+
+	cutoff := now.Add(-t.interval)
+	dropped := 0
+	for dropped < len(t.buckets) && t.buckets[dropped].start.Before(cutoff) {
+		dropped++
+	}
+	t.buckets = t.buckets[dropped:]
+}
+
+// counts aggregates every non-expired bucket's Requests/TotalSuccesses/
+// TotalFailures, and reports ConsecutiveSuccesses/ConsecutiveFailures
+// from the running counters on Tracking. Must be called with t.mutex
+// held.
+func (t *Tracking) counts() Counts {
+	t.rotate(time.Now())
+	agg := Counts{
+		ConsecutiveSuccesses: t.consecutiveSuccesses,
+		ConsecutiveFailures:  t.consecutiveFailures,
+	}
+	for i := range t.buckets {
+		agg.Requests += t.buckets[i].requests
+		agg.TotalSuccesses += t.buckets[i].totalSuccesses
+		agg.TotalFailures += t.buckets[i].totalFailures
 	}
+	return agg
+}
+
+func (t *Tracking) recordSuccess() {
+	t.currentBucket(time.Now()).onSuccess()
+	t.consecutiveSuccesses++
+	t.consecutiveFailures = 0
+}
+
+func (t *Tracking) recordFailure() {
+	t.currentBucket(time.Now()).onFailure()
+	t.consecutiveFailures++
+	t.consecutiveSuccesses = 0
+}
+
+// CircuitBreaker represents a circuit breaker.
+type CircuitBreaker struct {
+	name          string
+	tracking      *Tracking
+	isSuccessful  func(error) bool
+	onStateChange func(name string, from, to State)
+	onOpen        func()
+	onClose       func()
+	onHalfOpen    func()
 }
 
-// reset resets the circuit breaker to closed state.
-func (cb *CircuitBreaker) reset() {
-	cb.state = StateClosed
-	cb.consecutiveFailures = 0
-	cb.consecutiveSuccesses = 0
-	if cb.onClose != nil {
-		cb.onClose()
+// NewCircuitBreaker creates a new CircuitBreaker instance identified by
+// name (used by OnStateChange and Group). Counts are aggregated over a
+// sliding window of the given interval, split into buckets that are
+// dropped as they expire; readyToTrip is evaluated against the
+// aggregated Counts after every failure while closed. A nil readyToTrip
+// falls back to tripping once the window has at least 20 requests with
+// a failure ratio above 60%. maxRequests caps how many probes are
+// admitted per HALF-OPEN period. isSuccessful classifies the
+// error returned by fn as a success or failure for accounting purposes
+// - e.g. so an HTTP 4xx or context.Canceled doesn't count against a
+// breaker protecting the backend. A nil isSuccessful falls back to
+// treating any non-nil error as a failure.
+func NewCircuitBreaker(name string, timeout time.Duration, maxRequests int, interval time.Duration, readyToTrip func(Counts) bool, isSuccessful func(error) bool) *CircuitBreaker {
+	if isSuccessful == nil {
+		isSuccessful = defaultIsSuccessful
 	}
+	cb := &CircuitBreaker{
+		name:         name,
+		tracking:     NewTracking(timeout, maxRequests, interval, readyToTrip),
+		isSuccessful: isSuccessful,
+	}
+	cb.tracking.onStateChange = cb.handleStateChange
+	return cb
 }
 
-// SetOnOpen sets the callback for when the circuit breaker opens.
+// handleStateChange fans a Tracking state transition out to the
+// unified OnStateChange callback and the legacy SetOnOpen/Close/HalfOpen
+// sugar setters.
+func (cb *CircuitBreaker) handleStateChange(from, to State) {
+	if cb.onStateChange != nil {
+		cb.onStateChange(cb.name, from, to)
+	}
+	switch to {
+	case StateOpen:
+		if cb.onOpen != nil {
+			cb.onOpen()
+		}
+	case StateClosed:
+		if cb.onClose != nil {
+			cb.onClose()
+		}
+	case StateHalfOpen:
+		if cb.onHalfOpen != nil {
+			cb.onHalfOpen()
+		}
+	}
+}
+
+// Name returns the breaker's name.
+func (cb *CircuitBreaker) Name() string {
+	return cb.name
+}
+
+// State returns the breaker's current state.
+func (cb *CircuitBreaker) State() State {
+	return cb.tracking.State()
+}
+
+// Counts returns the Counts aggregated over the breaker's current
+// sliding window.
+func (cb *CircuitBreaker) Counts() Counts {
+	return cb.tracking.Counts()
+}
+
+// SetOnStateChange sets the callback invoked on every state transition
+// with the breaker's name, the prior state, and the new state.
+func (cb *CircuitBreaker) SetOnStateChange(callback func(name string, from, to State)) {
+	cb.onStateChange = callback
+}
+
+// defaultIsSuccessful treats any non-nil error as a failure.
+func defaultIsSuccessful(err error) bool {
+	return err == nil
+}
+
+// Execute executes the given function with circuit breaker logic. It is
+// a shim over the generic Execute for callers that have no result to
+// smuggle out of the closure.
+func (cb *CircuitBreaker) Execute(fn func() error) error {
+	_, err := Execute(cb, func() (struct{}, error) {
+		return struct{}{}, fn()
+	})
+	return err
+}
+
+// Execute runs fn with circuit breaker logic and returns its typed
+// result, so callers wrapping HTTP or RPC calls don't have to smuggle
+// the result out via a captured variable in a func() error closure.
+//
+// While HALF-OPEN, up to MaxRequests calls are admitted as probes per
+// HALF-OPEN period; callers beyond that budget get ErrTooManyRequests
+// immediately rather than blocking.
+func Execute[T any](cb *CircuitBreaker, fn func() (T, error)) (T, error) {
+	generation, err := cb.tracking.BeforeRequest()
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	result, err := fn()
+	cb.tracking.AfterRequest(generation, cb.isSuccessful(err))
+	return result, err
+}
+
+// SetOnOpen sets the callback for when the circuit breaker opens. It is
+// sugar over SetOnStateChange for callers that only care about one
+// transition.
 func (cb *CircuitBreaker) SetOnOpen(callback func()) {
 	cb.onOpen = callback
 }
 
-// SetOnClose sets the callback for when the circuit breaker closes.
+// SetOnClose sets the callback for when the circuit breaker closes. It
+// is sugar over SetOnStateChange for callers that only care about one
+// transition.
 func (cb *CircuitBreaker) SetOnClose(callback func()) {
 	cb.onClose = callback
 }
 
-// SetOnHalfOpen sets the callback for when the circuit breaker transitions to half-open.
+// SetOnHalfOpen sets the callback for when the circuit breaker
+// transitions to half-open. It is sugar over SetOnStateChange for
+// callers that only care about one transition.
 func (cb *CircuitBreaker) SetOnHalfOpen(callback func()) {
 	cb.onHalfOpen = callback
 }
+
+// Group manages a set of named CircuitBreaker instances, letting a
+// service keyed by endpoint or operation iterate and look up its
+// breakers instead of hand-maintaining its own map - e.g. for
+// Prometheus exporters or structured logging of breaker state.
+type Group struct {
+	mutex    sync.RWMutex
+	breakers map[string]*CircuitBreaker
+}
+
+// NewGroup creates an empty Group.
+func NewGroup() *Group {
+	return &Group{breakers: make(map[string]*CircuitBreaker)}
+}
+
+// Register adds cb to the group under cb.Name(), replacing any breaker
+// previously registered under that name.
+func (g *Group) Register(cb *CircuitBreaker) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.breakers[cb.Name()] = cb
+}
+
+// Get returns the breaker registered under name, if any.
+func (g *Group) Get(name string) (*CircuitBreaker, bool) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	cb, ok := g.breakers[name]
+	return cb, ok
+}
+
+// All returns every breaker currently registered, in no particular
+// order.
+func (g *Group) All() []*CircuitBreaker {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	all := make([]*CircuitBreaker, 0, len(g.breakers))
+	for _, cb := range g.breakers {
+		all = append(all, cb)
+	}
+	return all
+}
@@ -0,0 +1,157 @@
+package gosafecircuit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTracking_TripOnFailureRatio(t *testing.T) {
+	readyToTrip := func(c Counts) bool {
+		return c.Requests >= 3 && c.TotalFailures == c.Requests
+	}
+	tr := NewTracking(50*time.Millisecond, 1, time.Minute, readyToTrip)
+
+	for i := 0; i < 2; i++ {
+		gen, err := tr.BeforeRequest()
+		if err != nil {
+			t.Fatalf("BeforeRequest() unexpected error: %v", err)
+		}
+		tr.AfterRequest(gen, false)
+	}
+	if got := tr.State(); got != StateClosed {
+		t.Fatalf("State() = %v after 2 failures, want StateClosed", got)
+	}
+
+	gen, err := tr.BeforeRequest()
+	if err != nil {
+		t.Fatalf("BeforeRequest() unexpected error: %v", err)
+	}
+	tr.AfterRequest(gen, false)
+
+	if got := tr.State(); got != StateOpen {
+		t.Fatalf("State() = %v after readyToTrip satisfied, want StateOpen", got)
+	}
+}
+
+func TestTracking_HalfOpenAdmitsOnlyMaxRequestsProbes(t *testing.T) {
+	tr := NewTracking(10*time.Millisecond, 2, time.Minute, func(c Counts) bool {
+		return c.TotalFailures >= 1
+	})
+
+	gen, err := tr.BeforeRequest()
+	if err != nil {
+		t.Fatalf("BeforeRequest() unexpected error: %v", err)
+	}
+	tr.AfterRequest(gen, false)
+	if got := tr.State(); got != StateOpen {
+		t.Fatalf("State() = %v after tripping failure, want StateOpen", got)
+	}
+
+	time.Sleep(15 * time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		if _, err := tr.BeforeRequest(); err != nil {
+			t.Fatalf("BeforeRequest() probe %d: unexpected error: %v", i, err)
+		}
+	}
+	if got := tr.State(); got != StateHalfOpen {
+		t.Fatalf("State() = %v after entering probe window, want StateHalfOpen", got)
+	}
+
+	if _, err := tr.BeforeRequest(); err != ErrTooManyRequests {
+		t.Fatalf("BeforeRequest() past MaxRequests = %v, want ErrTooManyRequests", err)
+	}
+}
+
+func TestTracking_HalfOpenClosesAfterMaxRequestsSuccesses(t *testing.T) {
+	tr := NewTracking(10*time.Millisecond, 2, time.Minute, func(c Counts) bool {
+		return c.TotalFailures >= 1
+	})
+
+	gen, err := tr.BeforeRequest()
+	if err != nil {
+		t.Fatalf("BeforeRequest() unexpected error: %v", err)
+	}
+	tr.AfterRequest(gen, false)
+	time.Sleep(15 * time.Millisecond)
+
+	gen1, err := tr.BeforeRequest()
+	if err != nil {
+		t.Fatalf("BeforeRequest() probe 1: unexpected error: %v", err)
+	}
+	tr.AfterRequest(gen1, true)
+	if got := tr.State(); got != StateHalfOpen {
+		t.Fatalf("State() = %v after 1 of 2 successful probes, want StateHalfOpen", got)
+	}
+
+	gen2, err := tr.BeforeRequest()
+	if err != nil {
+		t.Fatalf("BeforeRequest() probe 2: unexpected error: %v", err)
+	}
+	tr.AfterRequest(gen2, true)
+	if got := tr.State(); got != StateClosed {
+		t.Fatalf("State() = %v after MaxRequests successful probes, want StateClosed", got)
+	}
+}
+
+func TestTracking_HalfOpenReopensOnFailureWithFreshTimeout(t *testing.T) {
+	tr := NewTracking(20*time.Millisecond, 2, time.Minute, func(c Counts) bool {
+		return c.TotalFailures >= 1
+	})
+
+	gen, err := tr.BeforeRequest()
+	if err != nil {
+		t.Fatalf("BeforeRequest() unexpected error: %v", err)
+	}
+	tr.AfterRequest(gen, false)
+	time.Sleep(25 * time.Millisecond)
+
+	gen, err = tr.BeforeRequest()
+	if err != nil {
+		t.Fatalf("BeforeRequest() probe: unexpected error: %v", err)
+	}
+	tr.AfterRequest(gen, false)
+
+	if got := tr.State(); got != StateOpen {
+		t.Fatalf("State() = %v after a failed probe, want StateOpen", got)
+	}
+
+	// The re-trip must start a fresh timeout: an immediate retry should
+	// still be rejected rather than admitted as a new probe.
+	if _, err := tr.BeforeRequest(); err != ErrOpenState {
+		t.Fatalf("BeforeRequest() immediately after re-trip = %v, want ErrOpenState", err)
+	}
+}
+
+func TestTracking_AfterRequestIgnoresStaleGeneration(t *testing.T) {
+	tr := NewTracking(50*time.Millisecond, 1, time.Minute, func(c Counts) bool {
+		return c.TotalFailures >= 1
+	})
+
+	staleGen, err := tr.BeforeRequest()
+	if err != nil {
+		t.Fatalf("BeforeRequest() unexpected error: %v", err)
+	}
+	tr.AfterRequest(staleGen, false)
+	if got := tr.State(); got != StateOpen {
+		t.Fatalf("State() = %v after tripping failure, want StateOpen", got)
+	}
+
+	// This outcome belongs to the generation that existed before the
+	// trip; it must be discarded while the breaker is open.
+	tr.AfterRequest(staleGen, true)
+	if got := tr.State(); got != StateOpen {
+		t.Fatalf("State() = %v after a stale success, want StateOpen", got)
+	}
+
+	// Once HALF-OPEN with a one-probe budget, a counted success would
+	// close the breaker; the stale outcome must still be ignored.
+	time.Sleep(60 * time.Millisecond)
+	if _, err := tr.BeforeRequest(); err != nil {
+		t.Fatalf("BeforeRequest() probe: unexpected error: %v", err)
+	}
+	tr.AfterRequest(staleGen, true)
+	if got := tr.State(); got != StateHalfOpen {
+		t.Fatalf("State() = %v after a stale success while HALF-OPEN, want StateHalfOpen", got)
+	}
+}